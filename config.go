@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// A Color is the RGB value of a button for a mode.
+type Color struct {
+	Red   int
+	Green int
+	Blue  int
+}
+
+// A Config is a snapshot of the device configuration. When applied, a
+// StartupWay of 0, a nil Silent and modes missing from Colors are left
+// unchanged.
+type Config struct {
+	StartupWay int
+	Silent     *bool
+	Colors     map[string]Color
+}
+
+// GetConfig retrieves the actual (temporary) configuration of the device.
+func (g *GRSDevice) GetConfig() (Config, error) {
+	startupWay, err := g.getStartupWay()
+	if err != nil {
+		return Config{}, err
+	}
+	silent, err := g.getSilent()
+	if err != nil {
+		return Config{}, err
+	}
+	c := Config{
+		StartupWay: startupWay,
+		Silent:     &silent,
+		Colors:     make(map[string]Color),
+	}
+	for _, mode := range []string{"4", "8", "keyboard"} {
+		color, err := g.getColor(mode)
+		if err != nil {
+			return Config{}, err
+		}
+		c.Colors[mode] = color
+	}
+	return c, nil
+}
+
+// PrintUnsaved prints the live (temporary) configuration followed by the raw
+// EEPROM dump. The EEPROM layout is not documented, so the two can only be
+// compared by eye.
+func (g *GRSDevice) PrintUnsaved() {
+	c, err := g.GetConfig()
+	if err != nil {
+		fatal(fmt.Errorf("unable to read configuration: %w", err))
+	}
+	fmt.Println("Live (temporary) configuration:")
+	fmt.Printf("Startup Orientation: %d\n", c.StartupWay)
+	fmt.Printf("Silent: %t\n", *c.Silent)
+	for _, m := range []struct{ mode, name string }{{"4", "4-way"}, {"8", "8-way"}, {"keyboard", "Keyboard"}} {
+		color := c.Colors[m.mode]
+		fmt.Printf("%s Color: %d,%d,%d\n", m.name, color.Red, color.Green, color.Blue)
+	}
+	fmt.Println()
+	fmt.Println("Persisted (EEPROM) configuration (layout undocumented, compare by eye):")
+	g.DumpEEPROM()
+}
+
+// An ApplyError is returned by ApplyConfig when a setting could not be
+// applied. Applied is the number of settings applied before the failure and
+// RollbackErr is nil if they were restored.
+type ApplyError struct {
+	Err         error
+	Applied     int
+	RollbackErr error
+}
+
+func (e *ApplyError) Error() string {
+	if e.Applied == 0 {
+		return fmt.Sprintf("%s (nothing to roll back)", e.Err)
+	}
+	if e.RollbackErr != nil {
+		return fmt.Sprintf("%s (rollback failed: %s)", e.Err, e.RollbackErr)
+	}
+	return fmt.Sprintf("%s (rolled back)", e.Err)
+}
+
+func (e *ApplyError) Unwrap() error {
+	return e.Err
+}
+
+// ApplyConfig applies the startup way, silent mode and colors set in cfg, in
+// that order. The affected settings are read first and, if any setting fails
+// to apply, the settings already applied are restored on a best-effort basis.
+//
+// Like the Set* methods, the settings are temporary until made permanent with
+// *GRSDevice.MakePermanent().
+func (g *GRSDevice) ApplyConfig(cfg Config) error {
+	if cfg.StartupWay != 0 && !isValidWay(cfg.StartupWay) {
+		return fmt.Errorf("invalid startup way: %d", cfg.StartupWay)
+	}
+	for mode, c := range cfg.Colors {
+		if !isValidMode(mode) {
+			return fmt.Errorf("invalid mode: %s", mode)
+		}
+		if !isValidColor(c.Red) || !isValidColor(c.Green) || !isValidColor(c.Blue) {
+			return fmt.Errorf("invalid color for mode %s: %d,%d,%d", mode, c.Red, c.Green, c.Blue)
+		}
+	}
+
+	snapshot, err := g.GetConfig()
+	if err != nil {
+		return fmt.Errorf("unable to read configuration: %w", err)
+	}
+
+	var undo []func() error
+	apply := func(do, revert func() error) error {
+		if err := do(); err != nil {
+			return err
+		}
+		undo = append(undo, revert)
+		return nil
+	}
+	rollback := func(err error) error {
+		if len(undo) == 0 {
+			return &ApplyError{Err: err}
+		}
+		var rollbackErr error
+		for i := len(undo) - 1; i >= 0; i-- {
+			if e := undo[i](); e != nil && rollbackErr == nil {
+				rollbackErr = e
+			}
+		}
+		if rollbackErr != nil {
+			log.Printf("ERROR: rollback failed: %s\n", rollbackErr)
+		} else {
+			log.Printf("Rolled back configuration")
+		}
+		return &ApplyError{Err: err, Applied: len(undo), RollbackErr: rollbackErr}
+	}
+
+	if cfg.StartupWay != 0 {
+		err := apply(
+			func() error { return g.applySetting(fmt.Sprintf("setstartupway,%d", cfg.StartupWay)) },
+			func() error { return g.applySetting(fmt.Sprintf("setstartupway,%d", snapshot.StartupWay)) },
+		)
+		if err != nil {
+			return rollback(err)
+		}
+	}
+
+	if cfg.Silent != nil {
+		err := apply(
+			func() error { return g.applySetting(silentCommand(*cfg.Silent)) },
+			func() error { return g.applySetting(silentCommand(*snapshot.Silent)) },
+		)
+		if err != nil {
+			return rollback(err)
+		}
+	}
+
+	for _, mode := range []string{"4", "8", "keyboard"} {
+		c, ok := cfg.Colors[mode]
+		if !ok {
+			continue
+		}
+		mode, old := mode, snapshot.Colors[mode]
+		err := apply(
+			func() error { return g.applySetting(colorCommand(mode, c)) },
+			func() error { return g.applySetting(colorCommand(mode, old)) },
+		)
+		if err != nil {
+			return rollback(err)
+		}
+	}
+
+	return nil
+}
+
+// applySetting sends a set command and checks that the device acknowledged it.
+func (g *GRSDevice) applySetting(cmd string) error {
+	r, err := g.exec(cmd)
+	if err != nil {
+		return fmt.Errorf("%s: %w", cmd, err)
+	}
+	if r != "ok" {
+		return fmt.Errorf("%s: %s", cmd, r)
+	}
+	return nil
+}
+
+func colorCommand(mode string, c Color) string {
+	return fmt.Sprintf("setcolor,%s,%d,%d,%d", mode, c.Red, c.Green, c.Blue)
+}
+
+func silentCommand(silent bool) string {
+	if silent {
+		return "setsilent,on"
+	}
+	return "setsilent,off"
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplyConfigLeavesUnsetSettings(t *testing.T) {
+	g, r := newRecordingDevice()
+	r.live.silent = true
+
+	red := Color{Red: 255}
+	if err := g.ApplyConfig(Config{Colors: map[string]Color{"4": red}}); err != nil {
+		t.Fatalf("ApplyConfig() = %v", err)
+	}
+
+	for _, cmd := range r.sent {
+		if strings.HasPrefix(cmd, "set") && !strings.HasPrefix(cmd, "setcolor,4,") {
+			t.Errorf("ApplyConfig() sent %q for an unset setting", cmd)
+		}
+	}
+	if !r.live.silent {
+		t.Errorf("ApplyConfig() turned off silent mode")
+	}
+	if got := r.live.colors["4"]; got != red {
+		t.Errorf("4-way color = %v, want %v", got, red)
+	}
+}
+
+// rejectingDevice is a simulator that rejects the commands starting with any
+// of the prefixes in reject.
+type rejectingDevice struct {
+	*simulator
+	reject []string
+}
+
+func (r *rejectingDevice) Write(b []byte) (int, error) {
+	for _, prefix := range r.reject {
+		if strings.HasPrefix(string(b), prefix) {
+			r.output = []byte("error\r\n")
+			return len(b), nil
+		}
+	}
+	return r.simulator.Write(b)
+}
+
+func TestApplyConfigRollback(t *testing.T) {
+	silent := true
+	cfg := Config{
+		StartupWay: 4,
+		Silent:     &silent,
+		Colors: map[string]Color{
+			"4": {Red: 1, Green: 2, Blue: 3},
+			"8": {Red: 4, Green: 5, Blue: 6},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		reject       []string
+		applied      int
+		rollbackFail bool
+	}{
+		{"rolled back", []string{"setcolor,8,"}, 3, false},
+		{"rollback failed", []string{"setcolor,8,", "setstartupway,8"}, 3, true},
+		{"nothing applied", []string{"setstartupway,4"}, 0, false},
+	}
+	for _, tt := range tests {
+		sim := newSimulator()
+		want := sim.live.clone()
+		g := &GRSDevice{device: &rejectingDevice{simulator: sim, reject: tt.reject}}
+
+		err := g.ApplyConfig(cfg)
+		applyErr, ok := err.(*ApplyError)
+		if !ok {
+			t.Fatalf("%s: ApplyConfig() = %v, want *ApplyError", tt.name, err)
+		}
+		if applyErr.Applied != tt.applied {
+			t.Errorf("%s: Applied = %d, want %d", tt.name, applyErr.Applied, tt.applied)
+		}
+		if (applyErr.RollbackErr != nil) != tt.rollbackFail {
+			t.Errorf("%s: RollbackErr = %v, want failure %t", tt.name, applyErr.RollbackErr, tt.rollbackFail)
+		}
+		if tt.applied == 0 && !strings.Contains(err.Error(), "nothing to roll back") {
+			t.Errorf("%s: Error() = %q, want nothing to roll back", tt.name, err)
+		}
+		if tt.rollbackFail {
+			continue
+		}
+
+		if sim.live.startupWay != want.startupWay {
+			t.Errorf("%s: startup way = %d, want %d", tt.name, sim.live.startupWay, want.startupWay)
+		}
+		if sim.live.silent != want.silent {
+			t.Errorf("%s: silent = %t, want %t", tt.name, sim.live.silent, want.silent)
+		}
+		for mode, c := range want.colors {
+			if sim.live.colors[mode] != c {
+				t.Errorf("%s: %s color = %v, want %v", tt.name, mode, sim.live.colors[mode], c)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// chunkedDevice answers dumpeeprom with a dump longer than a single read,
+// sent in 64 byte chunks like a USB serial port, and echoes other commands.
+type chunkedDevice struct {
+	dump   string
+	output []byte
+}
+
+func (c *chunkedDevice) Write(b []byte) (int, error) {
+	if string(b) == "dumpeeprom" {
+		c.output = []byte(c.dump + "\r\n")
+	} else {
+		c.output = []byte(string(b) + "\r\n")
+	}
+	return len(b), nil
+}
+
+func (c *chunkedDevice) Read(b []byte) (int, error) {
+	if len(b) > 64 {
+		b = b[:64]
+	}
+	n := copy(b, c.output)
+	c.output = c.output[n:]
+	return n, nil
+}
+
+func (c *chunkedDevice) Close() error {
+	return nil
+}
+
+func TestDumpEEPROMReadsWholeDump(t *testing.T) {
+	dump := strings.Repeat("00 01 02 03 04 05 06 07 08 09 0a 0b 0c 0d 0e 0f\r\n", 16)
+	dump = strings.TrimRight(dump, "\r\n")
+	g := &GRSDevice{device: &chunkedDevice{dump: dump}, timeout: time.Second}
+
+	got, err := g.dumpEEPROM()
+	if err != nil {
+		t.Fatalf("dumpEEPROM() = %v", err)
+	}
+	if got != dump {
+		t.Errorf("dumpEEPROM() returned %d bytes, want %d", len(got), len(dump))
+	}
+
+	// Nothing of the dump is left to be read as the reply to the next command.
+	if r, err := g.exec("getsilent"); err != nil || r != "getsilent" {
+		t.Errorf("exec(getsilent) = %q, %v, want the reply to getsilent", r, err)
+	}
+}
+
+func TestDumpEEPROMRequiresTimeout(t *testing.T) {
+	g := &GRSDevice{device: &chunkedDevice{dump: "00"}}
+	if _, err := g.dumpEEPROM(); err == nil {
+		t.Errorf("dumpEEPROM() without a timeout succeeded, want error")
+	}
+}
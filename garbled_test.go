@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestIsGarbled(t *testing.T) {
+	tests := []struct {
+		r    string
+		want bool
+	}{
+		{"", false},
+		{"tos428 v1.2", false},
+		{"ok\r\n", false},
+		{"KEY_LEFT_CTRL\r\nKEY_LEFT_ALT", false},
+		{"\x00\x00\x00\x00", true},
+		{"\xff\xfe\xf0\x80\x81", true},
+		{"ok\x00\x00\xff\xfe", true},
+		{"tos428 v1.2\x00", false},
+	}
+	for _, tt := range tests {
+		if got := isGarbled(tt.r); got != tt.want {
+			t.Errorf("isGarbled(%q) = %t, want %t", tt.r, got, tt.want)
+		}
+	}
+}
+
+// garbledDevice answers every command with bytes read at the wrong baud rate.
+type garbledDevice struct {
+	failingDevice
+}
+
+func (g *garbledDevice) Write(b []byte) (int, error) {
+	g.output = []byte("\xf8\x00\xe0\x80\xfe\x00\x1c\xff")
+	return len(b), nil
+}
+
+func TestUnreadableResponse(t *testing.T) {
+	g := &GRSDevice{device: &garbledDevice{}}
+	if _, err := g.getStartupWay(); !errors.Is(err, errGarbled) {
+		t.Errorf("getStartupWay() = %v, want %v", err, errGarbled)
+	}
+	if err := g.Handshake(); !errors.Is(err, errGarbled) {
+		t.Errorf("Handshake() = %v, want %v", err, errGarbled)
+	}
+}
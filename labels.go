@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// restrictors are the letters of the restrictors of a tos428.
+var restrictors = []string{"a", "b", "c", "d"}
+
+// parseLabels parses a comma separated list of restrictor=label pairs, e.g.
+// a=p1-left,b=p1-right, and returns the label of each restrictor.
+func parseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	if s == "" {
+		return labels, nil
+	}
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(s, ",") {
+		restrictor, label, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok || label == "" {
+			return nil, fmt.Errorf("expected restrictor=label: %q", field)
+		}
+		if strings.IndexFunc(label, unicode.IsSpace) >= 0 {
+			return nil, fmt.Errorf("label can not contain whitespace: %q", label)
+		}
+		if seen[strings.ToLower(label)] {
+			return nil, fmt.Errorf("duplicate label: %s", label)
+		}
+		seen[strings.ToLower(label)] = true
+		if restrictor == "all" || !isValidRestrictor(restrictor) {
+			return nil, fmt.Errorf("invalid restrictor: %s", restrictor)
+		}
+		if _, ok := labels[restrictor]; ok {
+			return nil, fmt.Errorf("duplicate restrictor: %s", restrictor)
+		}
+		if l := strings.ToLower(label); l == "all" || isValidRestrictor(l) {
+			return nil, fmt.Errorf("label can not be a restrictor: %s", label)
+		}
+		labels[restrictor] = label
+	}
+	return labels, nil
+}
+
+// normalizeRestrictor returns the restrictor letter for a label, or
+// restrictor unchanged if it is not a label.
+func normalizeRestrictor(restrictor string) string {
+	for r, label := range restrictorLabels {
+		if strings.EqualFold(label, restrictor) {
+			return r
+		}
+	}
+	return restrictor
+}
+
+// restrictorName returns the restrictor letter followed by its label, if any.
+func restrictorName(restrictor string) string {
+	if label, ok := restrictorLabels[restrictor]; ok {
+		return fmt.Sprintf("%s (%s)", restrictor, label)
+	}
+	return restrictor
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	got, err := parseLabels("a=p1-left,b=p1-right")
+	if err != nil {
+		t.Fatalf("parseLabels() = %v", err)
+	}
+	want := map[string]string{"a": "p1-left", "b": "p1-right"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLabels() = %v, want %v", got, want)
+	}
+}
+
+func TestParseLabelsInvalid(t *testing.T) {
+	for _, s := range []string{
+		"a",
+		"e=p1",
+		"all=p1",
+		"a=b",
+		"a=ALL",
+		"b=A",
+		"a=x,a=y",
+		"a=p1,b=p1",
+		"a=p1,b=P1",
+		"a=p1 left",
+	} {
+		if _, err := parseLabels(s); err == nil {
+			t.Errorf("parseLabels(%q) succeeded, want error", s)
+		}
+	}
+}
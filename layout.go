@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// readLayouts parses a layout file. Each line maps a rom to the way of each
+// restrictor, e.g.
+//
+//	pacman.zip: a=4,b=8,c=8,d=8
+//
+// Restrictors can also be given by label. Blank lines and lines starting with
+// # are ignored.
+func readLayouts(data []byte) (map[string]map[string]int, error) {
+	layouts := make(map[string]map[string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if !isRomLine(line) {
+			continue
+		}
+		rom, spec, ok := strings.Cut(line, ":")
+		rom = strings.TrimSpace(rom)
+		if !ok || rom == "" {
+			return nil, fmt.Errorf("line %d: expected rom: restrictor=way,...", n)
+		}
+		if _, ok := layouts[rom]; ok {
+			return nil, fmt.Errorf("line %d: duplicate rom: %s", n, rom)
+		}
+		layout := make(map[string]int)
+		for _, field := range strings.Split(spec, ",") {
+			restrictor, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected restrictor=way: %q", n, field)
+			}
+			restrictor = normalizeRestrictor(restrictor)
+			if restrictor == "all" || !isValidRestrictor(restrictor) {
+				return nil, fmt.Errorf("line %d: invalid restrictor: %s", n, restrictor)
+			}
+			way, err := strconv.Atoi(value)
+			if err != nil || !isValidWay(way) {
+				return nil, fmt.Errorf("line %d: invalid way: %s", n, value)
+			}
+			if _, ok := layout[restrictor]; ok {
+				return nil, fmt.Errorf("line %d: duplicate restrictor: %s", n, restrictor)
+			}
+			layout[restrictor] = way
+		}
+		layouts[rom] = layout
+	}
+	return layouts, scanner.Err()
+}
+
+// setLayout sets each restrictor in layout to its way.
+func (g *GRSDevice) setLayout(layout map[string]int) error {
+	var restrictors []string
+	for r := range layout {
+		restrictors = append(restrictors, r)
+	}
+	sort.Strings(restrictors)
+	for _, r := range restrictors {
+		if err := g.setPosition(r, layout[r]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadLayouts(t *testing.T) {
+	oldLabels := restrictorLabels
+	restrictorLabels = map[string]string{"b": "p1-right"}
+	defer func() { restrictorLabels = oldLabels }()
+
+	data := []byte(`# comment
+pacman.zip: a=4,b=8,c=8,d=8
+
+robotron.zip: a=8, p1-right=8
+`)
+	got, err := readLayouts(data)
+	if err != nil {
+		t.Fatalf("readLayouts() = %v", err)
+	}
+	want := map[string]map[string]int{
+		"pacman.zip":   {"a": 4, "b": 8, "c": 8, "d": 8},
+		"robotron.zip": {"a": 8, "b": 8},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readLayouts() = %v, want %v", got, want)
+	}
+}
+
+func TestReadLayoutsInvalid(t *testing.T) {
+	for _, data := range []string{
+		"pacman.zip a=4",
+		": a=4",
+		"pacman.zip: a",
+		"pacman.zip: a=4,",
+		"pacman.zip: all=4",
+		"pacman.zip: e=4",
+		"pacman.zip: a=5",
+		"pacman.zip: a=x",
+		"pacman.zip: a=4,a=8",
+		"pacman.zip: a=4\npacman.zip: b=4",
+	} {
+		if _, err := readLayouts([]byte(data)); err == nil {
+			t.Errorf("readLayouts(%q) succeeded, want error", data)
+		}
+	}
+}
+
+func TestSetWayForRomLayout(t *testing.T) {
+	oldLayouts, oldRoms := layouts, roms
+	layouts = map[string]map[string]int{
+		"pacman.zip": {"a": 4, "b": 8, "c": 8, "d": 4},
+	}
+	// The layout takes precedence over the rom list.
+	roms = []string{"pacman.zip"}
+	defer func() { layouts, roms = oldLayouts, oldRoms }()
+
+	g, r := newRecordingDevice()
+	if err := g.setWayForRom("/roms/pacman.zip"); err != nil {
+		t.Fatalf("setWayForRom() = %v", err)
+	}
+	want := []string{"setway,a,4", "setway,b,8", "setway,c,8", "setway,d,4"}
+	if !reflect.DeepEqual(r.sent, want) {
+		t.Errorf("setWayForRom() sent %q, want %q", r.sent, want)
+	}
+}
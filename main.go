@@ -8,41 +8,172 @@ import (
 	"bufio"
 	"bytes"
 	_ "embed"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
-	"github.com/tarm/serial"
 	"github.com/thoas/go-funk"
 )
 
+// Exit codes, so monitoring scripts can tell failures apart without parsing
+// output.
+const (
+	exitError        = 1
+	exitDeviceError  = 2
+	exitVerifyFailed = 3
+)
+
+// errDevice is wrapped by errors writing to or reading from the device.
+var errDevice = errors.New("device not responding")
+
+// errGarbled is returned when a response is unreadable, which usually means
+// the baud rate is wrong.
+var errGarbled = errors.New("device responded with unreadable data — possibly wrong baud rate; try -baud")
+
+// silentTimeout is the read timeout used with -silent-output, so a device that
+// does not respond fails instead of blocking.
+const silentTimeout = 5 * time.Second
+
+// dumpTimeout is the read timeout used with -unsaved when none is set, so the
+// end of the EEPROM dump can be detected.
+const dumpTimeout = time.Second
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+var audit bool
 var autoRom string
+var baudRate int
+var coverageDir string
 var devicePath string
 var deviceRestrictor string
 var exportFile string
+var exportHeader bool
 var getInfo bool
+var handshake bool
+var hexDump bool
+var labelsFlag string
+var layoutPath string
+var layouts map[string]map[string]int
+var listRestrictors bool
+var listWay int
+var lockConfig bool
+var mameMachines map[string]machineInfo
+var mameXMLPath string
 var mergeListPath string
+var ping bool
 var rawComand string
+var readTimeout time.Duration
+var restrictorLabels map[string]string
+var retries int
 var romListPath string
 var roms []string
+var setStartupWay int
 var setWay int
+var showVersion bool
+var silentOutput bool
+var simulate bool
+var statePath string
+var tcpAddr string
+var unsaved bool
+var verbose bool
+var verifyServos bool
+var verticalWay int
+var waitForDevice time.Duration
 
 //go:embed roms4way.txt
 var romsData []byte
 
+//go:embed roms8way.txt
+var roms8WayData []byte
+
 // A GRSDevice is a connection to a tos428
 type GRSDevice struct {
-	device *serial.Port
+	device io.ReadWriteCloser
+	// timeout is the read timeout of device, or 0 if reads block.
+	timeout time.Duration
 }
 
-func (g *GRSDevice) sendCommand(cmd string) {
-	_, err := g.device.Write([]byte(cmd))
+func (g *GRSDevice) write(cmd string) error {
+	if hexDump {
+		fmt.Fprintf(os.Stderr, "sent %d bytes:\n%s", len(cmd), hex.Dump([]byte(cmd)))
+	}
+	if _, err := g.device.Write([]byte(cmd)); err != nil {
+		return fmt.Errorf("%w: %s", errDevice, err)
+	}
+	return nil
+}
+
+func (g *GRSDevice) read() (string, error) {
+	buf := make([]byte, 128)
+	n, err := g.device.Read(buf)
+	if hexDump {
+		fmt.Fprintf(os.Stderr, "received %d bytes:\n%s", n, hex.Dump(buf[:n]))
+	}
 	if err != nil {
-		log.Fatal(err)
+		return "", fmt.Errorf("%w: %s", errDevice, err)
+	}
+	if n == 0 {
+		// The read timed out.
+		return "", errDevice
+	}
+	if isGarbled(string(buf[:n])) {
+		return "", errGarbled
+	}
+	return strings.TrimRight(string(buf[:n]), "\r\n"), nil
+}
+
+// readAll reads a response that can span several reads, such as the EEPROM
+// dump, until the device stops sending. The end of the response is detected
+// by a read timing out, so it requires a read timeout.
+func (g *GRSDevice) readAll() (string, error) {
+	if g.timeout == 0 {
+		return "", errors.New("reading this response requires a read timeout (-timeout)")
+	}
+	var out []byte
+	buf := make([]byte, 128)
+	for {
+		n, err := g.device.Read(buf)
+		if hexDump {
+			fmt.Fprintf(os.Stderr, "received %d bytes:\n%s", n, hex.Dump(buf[:n]))
+		}
+		out = append(out, buf[:n]...)
+		if n == 0 || err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("%w: %s", errDevice, err)
+		}
+	}
+	if len(out) == 0 {
+		return "", errDevice
+	}
+	if isGarbled(string(out)) {
+		return "", errGarbled
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+func (g *GRSDevice) exec(cmd string) (string, error) {
+	if err := g.write(cmd); err != nil {
+		return "", err
+	}
+	return g.read()
+}
+
+func (g *GRSDevice) sendCommand(cmd string) {
+	if err := g.write(cmd); err != nil {
+		fatal(err)
 	}
 }
 
@@ -52,47 +183,70 @@ func (g *GRSDevice) sendCommandWithOutput(cmd string) string {
 }
 
 func (g *GRSDevice) getOutput() string {
-	buf := make([]byte, 128)
-	n, err := g.device.Read(buf)
+	r, err := g.read()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
-	return strings.TrimRight(string(buf[:n]), "\r\n")
+	return r
 }
 
 // DumpEEPROM lists the actual static (EEPROM) memory where configurations are
 // permanently stored.
+//
+// The dump is longer than a single read, so the device must be opened with a
+// read timeout to detect its end.
 func (g *GRSDevice) DumpEEPROM() {
-	g.sendCommand("dumpeeprom")
-	r := g.getOutput()
+	r, err := g.dumpEEPROM()
+	if err != nil {
+		fatal(err)
+	}
 	fmt.Println(r)
 }
 
+func (g *GRSDevice) dumpEEPROM() (string, error) {
+	if err := g.write("dumpeeprom"); err != nil {
+		return "", err
+	}
+	return g.readAll()
+}
+
 // GetColor retrieves the actual color code for the modes given in P1
 // (4|8|keyboard)
 func (g *GRSDevice) GetColor(mode string) (int, int, int) {
+	c, err := g.getColor(mode)
+	if err != nil {
+		fatal(err)
+	}
+	return c.Red, c.Green, c.Blue
+}
+
+func (g *GRSDevice) getColor(mode string) (Color, error) {
 	cmd := fmt.Sprintf("getcolor,%s", mode)
-	g.sendCommand(cmd)
-	r := g.getOutput()
+	r, err := g.exec(cmd)
+	if err != nil {
+		return Color{}, err
+	}
 
 	rgb := strings.Split(r, ",")
 	if len(rgb) != 3 {
-		log.Fatalf("Invalid response from device %s\n", r)
+		return Color{}, fmt.Errorf("Invalid response from device %s", r)
 	}
 
 	var rgbInts []int
 	for _, c := range rgb {
 		i, err := strconv.Atoi(c)
-		if err == nil {
-			rgbInts = append(rgbInts, i)
+		if err != nil {
+			return Color{}, fmt.Errorf("Invalid response from device %s", r)
 		}
+		rgbInts = append(rgbInts, i)
 	}
 
-	return rgbInts[0], rgbInts[1], rgbInts[2]
+	return Color{Red: rgbInts[0], Green: rgbInts[1], Blue: rgbInts[2]}, nil
 }
 
 func (g *GRSDevice) GetInfo() {
 	log.Printf("Device: %s", g.GetWelcome())
+	log.Printf("Tool Version: %s", version)
 
 	startupWay := g.GetStartupWay()
 	log.Printf("Startup Orientation: %d", startupWay)
@@ -106,6 +260,11 @@ func (g *GRSDevice) GetInfo() {
 	red, green, blue = g.GetColor("keyboard")
 	log.Printf("Keyboard Color: %d,%d,%d", red, green, blue)
 
+	for _, r := range restrictors {
+		if label, ok := restrictorLabels[r]; ok {
+			log.Printf("Restrictor %s: %s", r, label)
+		}
+	}
 }
 
 // GetKeyList provides a list of supported symbolic key names to the remote
@@ -125,34 +284,83 @@ func (g *GRSDevice) GetKeyList() []string {
 // GetSilent retrieves the configuration regarding the behavior of the servos
 // when not in motion. Returns true if silent mode is enabled.
 func (g *GRSDevice) GetSilent() bool {
-	r := g.sendCommandWithOutput("getsilent")
-	silent, err := strconv.ParseBool(r)
+	silent, err := g.getSilent()
 	if err != nil {
-		log.Fatalf("ERROR: invalid response: %s", r)
+		fatal(err)
 	}
 	return silent
 }
 
+func (g *GRSDevice) getSilent() (bool, error) {
+	r, err := g.exec("getsilent")
+	if err != nil {
+		return false, err
+	}
+	silent, err := strconv.ParseBool(r)
+	if err != nil {
+		return false, fmt.Errorf("invalid response: %s", r)
+	}
+	return silent, nil
+}
+
 // GetStartupWay retrieves the actual configuration of restrictor orientation
 // after power up.
 func (g *GRSDevice) GetStartupWay() int {
-	cmd := "getstartupway"
-	g.sendCommand(cmd)
-	r := g.getOutput()
-	i, err := strconv.Atoi(r)
+	i, err := g.getStartupWay()
 	if err != nil {
-		log.Fatal("Unable to get Startup Orientation value")
+		fatal(fmt.Errorf("Unable to get Startup Orientation value: %w", err))
 	}
 	return i
 }
 
+func (g *GRSDevice) getStartupWay() (int, error) {
+	r, err := g.exec("getstartupway")
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.Atoi(r)
+	if err != nil {
+		return 0, fmt.Errorf("invalid startup way: %q", r)
+	}
+	return i, nil
+}
+
 // GetWelcome provides the product name and actual firmware version, so remote
 // system can check if connected to the right COM-port.
+//
+// The firmware has no separate command for build information; the version in
+// the welcome message is all that is available.
 func (g *GRSDevice) GetWelcome() string {
 	g.sendCommand("getwelcome")
 	return g.getOutput()
 }
 
+// Handshake confirms two-way communication with the device. It checks that
+// getwelcome returns a banner and that getsilent returns a value that can be
+// parsed, so a connection that opens but can not be queried is detected before
+// running any other command.
+func (g *GRSDevice) Handshake() error {
+	welcome, err := g.exec("getwelcome")
+	if errors.Is(err, errGarbled) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("getwelcome: %w", err)
+	}
+	if strings.TrimSpace(welcome) == "" {
+		return fmt.Errorf("getwelcome: device returned an empty banner")
+	}
+
+	r, err := g.exec("getsilent")
+	if err != nil {
+		return fmt.Errorf("getsilent: %w", err)
+	}
+	if _, err := strconv.ParseBool(r); err != nil {
+		return fmt.Errorf("getsilent: invalid response: %q", r)
+	}
+	return nil
+}
+
 // MakePermanent makes all temporary configuration permanent, so that they are
 // automatically loaded after each power on.
 func (g *GRSDevice) MakePermanent() {
@@ -182,6 +390,9 @@ func (g *GRSDevice) RestoreFactory() {
 // 8 sets color for 8-way position.
 // When button is configured as keybord key, keyboard will set the color for
 // that mode
+//
+// The firmware has no separate brightness control, so brightness is set
+// through the RGB values.
 func (g *GRSDevice) SetColor(mode string, red int, green int, blue int) {
 	if !isValidMode(mode) {
 		log.Fatalf("ERROR: Invalid mode: %s\n", mode)
@@ -195,7 +406,7 @@ func (g *GRSDevice) SetColor(mode string, red int, green int, blue int) {
 	if !isValidColor(blue) {
 		log.Fatalf("ERROR: Invalid value for blue: %d\n", blue)
 	}
-	cmd := fmt.Sprintf("setcolor,%s,%d,%d,%d")
+	cmd := fmt.Sprintf("setcolor,%s,%d,%d,%d", mode, red, green, blue)
 	r := g.sendCommandWithOutput(cmd)
 	if r != "ok" {
 		log.Fatalf("ERROR: error setting color: %s\n", r)
@@ -205,25 +416,35 @@ func (g *GRSDevice) SetColor(mode string, red int, green int, blue int) {
 // SetPosition sets restrictor to position way
 //
 // Valid values for restrictor are (all, a, b, c, d)
+//
+// The firmware has no command to stop a move in progress. To correct a
+// mistaken move, call SetPosition again with the intended way.
 func (g *GRSDevice) SetPosition(restrictor string, way int) {
-	validValues := []string{"all", "a", "b", "c", "d"}
-	if !funk.Contains(validValues, restrictor) {
-		log.Fatalf("ERROR: invalid restrictor value: %s\n", restrictor)
+	if err := g.setPosition(restrictor, way); err != nil {
+		fatal(err)
+	}
+}
+
+func (g *GRSDevice) setPosition(restrictor string, way int) error {
+	if !isValidRestrictor(restrictor) {
+		return fmt.Errorf("invalid restrictor value: %s", restrictor)
 	}
 	if !isValidWay(way) {
-		log.Fatalf("ERROR: invalid way: %d\n", way)
+		return fmt.Errorf("invalid way: %d", way)
 	}
 
-	log.Printf("Setting restrictor %s position to %d-way", restrictor, way)
+	log.Printf("Setting restrictor %s position to %d-way", restrictorName(restrictor), way)
 	cmd := fmt.Sprintf("setway,%s,%d", restrictor, way)
-	g.sendCommand(cmd)
-
-	r := g.getOutput()
+	r, err := g.exec(cmd)
+	if err != nil {
+		return err
+	}
 	if r != "ok" {
-		log.Fatalf("ERROR: \"%q\"\n", r)
+		return fmt.Errorf("%q", r)
 	}
 
 	log.Printf("Command completed successfully")
+	return nil
 }
 
 // SetSilent configures behavior of servos when not in motion. If silent is on,
@@ -245,98 +466,305 @@ func (g *GRSDevice) SetSilent(silent bool) {
 
 // SetStartupWay allows configuration to which position all restrictors will be
 // initialized/moved after power up.
-func (g *GRSDevice) SetStartupWay(way int) {
-	if way != 4 && way != 8 {
-		log.Fatalf("ERROR: invalid value %d\n", way)
+//
+// The firmware only stores a single startup way shared by all restrictors, so
+// the only valid value for restrictor is all.
+func (g *GRSDevice) SetStartupWay(restrictor string, way int) {
+	if err := g.setStartupWay(restrictor, way); err != nil {
+		fatal(err)
+	}
+	g.MakePermanent()
+}
+
+func (g *GRSDevice) setStartupWay(restrictor string, way int) error {
+	if restrictor != "all" {
+		return fmt.Errorf("startup way can only be set for all restrictors, not %s", restrictor)
+	}
+	if !isValidWay(way) {
+		return fmt.Errorf("invalid value %d", way)
 	}
 	cmd := fmt.Sprintf("setstartupway,%d", way)
-	r := g.sendCommandWithOutput(cmd)
+	r, err := g.exec(cmd)
+	if err != nil {
+		return err
+	}
 	if r != "ok" {
-		log.Fatalf("ERROR: unable to set startup way: %s\n", r)
+		return fmt.Errorf("unable to set startup way: %s", r)
 	}
-	g.MakePermanent()
+	return nil
 }
 
-// SetWayForRom sets the way based on rom.
+// SetWayForRom sets the way based on rom. Roms in the layout file have each
+// restrictor set as listed. Roms in the rom list are set to listWay. Other
+// roms are set to the way derived from the MAME metadata if it is loaded, or
+// to the other way.
 func (g *GRSDevice) SetWayForRom(rom string) {
-	log.Printf("Checking ROM: %s", autoRom)
+	if err := g.setWayForRom(rom); err != nil {
+		fatal(err)
+	}
+}
 
-	if funk.Contains(roms, filepath.Base(autoRom)) {
-		g.SetPosition(deviceRestrictor, 4)
-	} else {
-		g.SetPosition(deviceRestrictor, 8)
+func (g *GRSDevice) setWayForRom(rom string) error {
+	log.Printf("Checking ROM: %s", rom)
+
+	switch romSourceFor(rom) {
+	case sourceLayout:
+		return g.setLayout(layouts[filepath.Base(rom)])
+	case sourceList:
+		return g.setPosition(deviceRestrictor, listWay)
+	case sourceMame:
+		return g.setPosition(deviceRestrictor, mameWayForRom(rom))
+	default:
+		return g.setPosition(deviceRestrictor, otherWay(listWay))
 	}
 }
 
-func (g *GRSDevice) Init() {
-	c := &serial.Config{Name: devicePath, Baud: 115200}
-	d, err := serial.OpenPort(c)
-	if err != nil {
-		log.Fatal(err)
+// A romSource is where the way of a rom comes from.
+type romSource int
+
+const (
+	sourceLayout romSource = iota
+	sourceList
+	sourceMame
+	sourceDefault
+)
+
+// romSourceFor returns where the way of rom comes from, in order of
+// precedence: the layout file, the rom list, the MAME metadata and the
+// default.
+func romSourceFor(rom string) romSource {
+	if _, ok := layouts[filepath.Base(rom)]; ok {
+		return sourceLayout
+	}
+	if funk.Contains(roms, filepath.Base(rom)) {
+		return sourceList
+	}
+	if mameWayForRom(rom) != 0 {
+		return sourceMame
 	}
-	g.device = d
+	return sourceDefault
 }
 
-func findDevice() {
-	if devicePath == "auto" {
-		ttyDir := "/sys/class/tty"
-		files, err := os.ReadDir(ttyDir)
-		if err != nil {
-			log.Fatal(err)
-		}
-		for _, file := range files {
-			p, _ := filepath.EvalSymlinks(filepath.Join(ttyDir, file.Name()))
-			if strings.Contains(p, "usb") {
-				const productString = "PRODUCT=2341/8036/100"
-				ueventPath := filepath.Join(p, "..", "..", "uevent")
-				if _, err := os.Stat(ueventPath); err == nil {
-					body, _ := os.ReadFile(ueventPath)
-					if strings.Contains(string(body), productString) {
-						devicePath = filepath.Join("/dev", file.Name())
-						log.Printf("Found tos428: %s\n", devicePath)
-					}
+// Close closes the connection to the device.
+func (g *GRSDevice) Close() error {
+	return g.device.Close()
+}
+
+// findDevice scans for a connected tos428 and returns the path of the first
+// one found.
+func findDevice() (string, error) {
+	ttyDir := "/sys/class/tty"
+	files, err := os.ReadDir(ttyDir)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		p, _ := filepath.EvalSymlinks(filepath.Join(ttyDir, file.Name()))
+		if strings.Contains(p, "usb") {
+			const productString = "PRODUCT=2341/8036/100"
+			ueventPath := filepath.Join(p, "..", "..", "uevent")
+			if _, err := os.Stat(ueventPath); err == nil {
+				body, _ := os.ReadFile(ueventPath)
+				if strings.Contains(string(body), productString) {
+					path := filepath.Join("/dev", file.Name())
+					log.Printf("Found tos428: %s\n", path)
+					return path, nil
 				}
 			}
 		}
 	}
+	return "", errors.New("no tos428 found")
 }
 
 func initRomList() {
+	if err := loadRomLists(); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// loadRomLists reads the rom list, merge list, layout file and MAME metadata.
+// Nothing is replaced unless all of them are read successfully.
+func loadRomLists() error {
+	var list []string
 	if romListPath == "" {
-		readRomList(romsData)
+		list = readRomList(builtinRomList())
 	} else {
 		data, err := os.ReadFile(romListPath)
 		if err != nil {
-			log.Fatalln(err)
+			return err
 		}
-		readRomList(data)
+		list = readRomList(data)
 	}
 
 	if mergeListPath != "" {
 		data, err := os.ReadFile(mergeListPath)
 		if err != nil {
-			log.Fatalln(err)
+			return err
 		}
-		readRomList(data)
+		list = append(list, readRomList(data)...)
 	}
+
+	var l map[string]map[string]int
+	if layoutPath != "" {
+		data, err := os.ReadFile(layoutPath)
+		if err != nil {
+			return err
+		}
+		l, err = readLayouts(data)
+		if err != nil {
+			return fmt.Errorf("parsing layout file: %w", err)
+		}
+	}
+
+	var machines map[string]machineInfo
+	if mameXMLPath != "" {
+		var err error
+		machines, err = loadMameXML(mameXMLPath)
+		if err != nil {
+			return fmt.Errorf("parsing MAME xml: %w", err)
+		}
+	}
+
+	roms, layouts, mameMachines = list, l, machines
+	return nil
 }
 
 func init() {
 	flag.StringVar(&autoRom, "rom", "", "auto-detect the way for the specified rom")
-	flag.StringVar(&exportFile, "exportromlist", "", "exports the built-in 4-way rom list to specified path")
-	flag.StringVar(&romListPath, "romlist", "", "file containing list of 4-way roms. Defaults to built-in list.")
-	flag.StringVar(&mergeListPath, "mergelist", "", "file containing list of 4-way roms to merge with built-in list.")
+	flag.StringVar(&coverageDir, "coverage", "", "count the roms in the specified directory by where their way comes from and list the ones using the default")
+	flag.StringVar(&exportFile, "exportromlist", "", "exports the built-in rom list selected by -listway to specified path")
+	flag.BoolVar(&exportHeader, "exportheader", false, "include a comment header with the tool version, date and rom count when exporting the rom list")
+	flag.StringVar(&romListPath, "romlist", "", "file containing list of roms for -listway. Defaults to built-in list.")
+	flag.StringVar(&mameXMLPath, "mamexml", "", "file containing the output of mame -listxml. Used to derive the way of roms not in the rom list.")
+	flag.BoolVar(&audit, "audit", false, "list the roms in the rom list whose ways in -mamexml disagree with -listway")
+	flag.StringVar(&mergeListPath, "mergelist", "", "file containing list of roms for -listway to merge with built-in list.")
+	flag.StringVar(&layoutPath, "layout", "", "file mapping roms to the way of each restrictor (rom: a=4,b=8,c=8,d=8). Takes precedence over the rom list.")
+	flag.IntVar(&listWay, "listway", 4, "way of the roms in the rom list (4 or 8). Selects the built-in list; roms not in the list are set to the other way.")
 	flag.StringVar(&devicePath, "d", "auto", "path to tos428 device. Set to auto to scan for device. On Windows use COM#")
-	flag.StringVar(&deviceRestrictor, "r", "all", "restrictor to apply setting to")
+	flag.IntVar(&baudRate, "baud", 115200, "baud rate of the serial connection")
+	flag.StringVar(&deviceRestrictor, "r", "all", "restrictor (all, a, b, c, d or a label from -labels) to apply setting to")
+	flag.StringVar(&labelsFlag, "labels", "", "labels for the restrictors, e.g. a=p1-left,b=p1-right,c=p2-left,d=p2-right")
+	flag.BoolVar(&listRestrictors, "list", false, "list the restrictors and their labels")
+	flag.DurationVar(&readTimeout, "timeout", 0, "timeout for reading a response from the device. 0 waits forever.")
+	flag.DurationVar(&waitForDevice, "wait", 0, "how long to wait for a device to show up when -d is auto")
+	flag.IntVar(&retries, "retries", 0, "number of times to retry opening the device")
 	flag.StringVar(&rawComand, "raw", "", "raw command to send to the device. Used to support features not currently implemented.")
 	flag.BoolVar(&getInfo, "info", false, "display device info")
+	flag.BoolVar(&unsaved, "unsaved", false, "display the live (temporary) configuration next to the raw EEPROM dump")
+	flag.BoolVar(&hexDump, "hexdump", false, "print a hex dump of the bytes sent to and received from the device")
+	flag.BoolVar(&handshake, "handshake", false, "verify communication with the device before running the command")
+	flag.StringVar(&tcpAddr, "tcp", "", "serve a line protocol on the specified address (e.g. :9000). Commands: rom <name>, way <restrictor> <4|8>, info, lock, unlock (lock and unlock from localhost only)")
+	flag.BoolVar(&lockConfig, "lock", false, "start -tcp locked, rejecting manual way changes until unlocked")
+	flag.StringVar(&statePath, "statefile", "", "file to persist the -tcp state (lock) to")
+	flag.BoolVar(&simulate, "simulate", false, "use an in-memory simulated device instead of a tos428")
+	flag.BoolVar(&ping, "ping", false, "open the device, verify communication and exit")
+	flag.BoolVar(&silentOutput, "silent-output", false, "write nothing to stdout or stderr and report the result by exit code only: 1 error, 2 device not found or not responding, 3 servo verification failed. Implies -handshake and a 5s -timeout unless set.")
+	flag.BoolVar(&verbose, "verbose", false, "write output even with -silent-output")
+	flag.BoolVar(&showVersion, "version", false, "display the tool version")
+	flag.BoolVar(&verifyServos, "verify-servos", false, "move each restrictor to 4-way and 8-way and report which moves the device acknowledged. Positions can not be read back, so stuck servos are not detected. Restrictors are reset to the startup way afterwards.")
+	flag.IntVar(&verticalWay, "verticalway", 4, "way for vertical games when derived from -mamexml (4 or 8). Set to 0 to use the joystick ways of the game.")
 	flag.IntVar(&setWay, "way", 0, "way to set the restrictor (4 or 8)")
-	flag.Parse()
+	flag.IntVar(&setStartupWay, "startupway", 0, "way all restrictors are moved to after power up (4 or 8). Only -r all is supported.")
+}
+
+// parseFlags parses the command line and loads the rom lists.
+func parseFlags() {
+	// Parse errors exit with exitError rather than the flag package's 2, which
+	// would read as exitDeviceError.
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if isFlagSet(os.Args[1:], "silent-output") && !isFlagSet(os.Args[1:], "verbose") {
+		flag.CommandLine.SetOutput(io.Discard)
+	}
+	if err := flag.CommandLine.Parse(os.Args[1:]); err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err != nil {
+		os.Exit(exitError)
+	}
+
+	if silentOutput && readTimeout == 0 {
+		readTimeout = silentTimeout
+	}
+	if unsaved && readTimeout == 0 {
+		readTimeout = dumpTimeout
+	}
+	if silentOutput && !verbose {
+		log.SetOutput(io.Discard)
+		if null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+			os.Stdout = null
+			os.Stderr = null
+		}
+	}
+
+	if !isValidWay(listWay) {
+		log.Fatalf("invalid value for -listway: %d\n", listWay)
+	}
+	if verticalWay != 0 && !isValidWay(verticalWay) {
+		log.Fatalf("invalid value for -verticalway: %d\n", verticalWay)
+	}
+
+	labels, err := parseLabels(labelsFlag)
+	if err != nil {
+		log.Fatalf("invalid value for -labels: %s\n", err)
+	}
+	restrictorLabels = labels
+	deviceRestrictor = normalizeRestrictor(deviceRestrictor)
 
-	findDevice()
 	initRomList()
 }
 
+// isFlagSet reports whether the boolean flag name is set to true in args. It
+// is used to honour -silent-output before the flags are parsed.
+func isFlagSet(args []string, name string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			return false
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		arg = strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		flagName, value, hasValue := strings.Cut(arg, "=")
+		if flagName != name {
+			continue
+		}
+		if !hasValue {
+			return true
+		}
+		b, err := strconv.ParseBool(value)
+		return err == nil && b
+	}
+	return false
+}
+
+// exitCode returns exitDeviceError if err means the device did not respond or
+// its response was unreadable, or exitError otherwise.
+func exitCode(err error) int {
+	if errors.Is(err, errDevice) || errors.Is(err, errGarbled) {
+		return exitDeviceError
+	}
+	return exitError
+}
+
+// fatal logs err and exits with its exitCode.
+func fatal(err error) {
+	log.Printf("ERROR: %s\n", err)
+	os.Exit(exitCode(err))
+}
+
+// isGarbled reports whether more than a quarter of the characters in r are
+// not printable, which is what a response read at the wrong baud rate looks
+// like.
+func isGarbled(r string) bool {
+	var total, unreadable int
+	for _, c := range r {
+		total++
+		if c == utf8.RuneError || (!unicode.IsPrint(c) && !unicode.IsSpace(c)) {
+			unreadable++
+		}
+	}
+	return unreadable*4 > total
+}
+
 func isValidColor(color int) bool {
 	if color >= 0 && color <= 255 {
 		return true
@@ -352,14 +780,8 @@ func isValidMode(mode string) bool {
 }
 
 func isValidRestrictor(restrictor string) bool {
-	if restrictor == "all" {
-		return true
-	}
-	i, err := strconv.Atoi(restrictor)
-	if err != nil {
-		return false
-	}
-	return (i >= 1) && (i <= 4)
+	validValues := []string{"all", "a", "b", "c", "d"}
+	return funk.Contains(validValues, restrictor)
 }
 
 func isValidWay(way int) bool {
@@ -369,42 +791,182 @@ func isValidWay(way int) bool {
 	return true
 }
 
-func readRomList(data []byte) {
+// builtinRomList returns the built-in rom list for listWay.
+func builtinRomList() []byte {
+	if listWay == 8 {
+		return roms8WayData
+	}
+	return romsData
+}
+
+// printCoverage prints how many of the roms in dir get their way from the
+// layout file, the rom list, the MAME metadata or the default, followed by the
+// names of the roms that use the default.
+func printCoverage(dir string) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	counts := make(map[romSource]int)
+	var defaulting []string
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		source := romSourceFor(file.Name())
+		counts[source]++
+		if source == sourceDefault {
+			defaulting = append(defaulting, file.Name())
+		}
+	}
+	fmt.Printf("%d in layout file\n", counts[sourceLayout])
+	fmt.Printf("%d in rom list (%d-way)\n", counts[sourceList], listWay)
+	fmt.Printf("%d from MAME metadata\n", counts[sourceMame])
+	fmt.Printf("%d defaulting (%d-way)\n", counts[sourceDefault], otherWay(listWay))
+	for _, rom := range defaulting {
+		fmt.Println(rom)
+	}
+	return nil
+}
+
+// exportRomList writes the built-in rom list to path, optionally preceded by
+// a comment header describing the list.
+func exportRomList(path string, header bool) error {
+	var buf bytes.Buffer
+	if header {
+		var count int
+		scanner := bufio.NewScanner(bytes.NewReader(builtinRomList()))
+		for scanner.Scan() {
+			if isRomLine(scanner.Text()) {
+				count++
+			}
+		}
+		fmt.Fprintf(&buf, "# %d-way rom list exported by tos428 %s\n", listWay, version)
+		fmt.Fprintf(&buf, "# Date: %s\n", time.Now().Format(time.RFC3339))
+		fmt.Fprintf(&buf, "# Roms: %d\n", count)
+	}
+	buf.Write(builtinRomList())
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// isRomLine reports whether line of a rom list names a rom. Blank lines and
+// lines starting with # are ignored.
+func isRomLine(line string) bool {
+	rom := strings.TrimSpace(line)
+	return rom != "" && !strings.HasPrefix(rom, "#")
+}
+
+// otherWay returns 8 for 4 and 4 for 8.
+func otherWay(way int) int {
+	if way == 4 {
+		return 8
+	}
+	return 4
+}
+
+func readRomList(data []byte) []string {
+	var list []string
 	reader := bytes.NewReader(data)
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
-		rom := strings.TrimSpace(scanner.Text())
-		if rom != "" {
-			roms = append(roms, scanner.Text())
+		if isRomLine(scanner.Text()) {
+			list = append(list, strings.TrimSpace(scanner.Text()))
 		}
 	}
 	if err := scanner.Err(); err != nil {
 		log.Printf("Error parsing roms list: %s\n", err)
 	}
+	return list
 }
 
 func main() {
+	parseFlags()
+
+	if showVersion {
+		fmt.Println(version)
+		return
+	}
+
 	if exportFile != "" {
-		err := os.WriteFile(exportFile, romsData, 0644)
+		err := exportRomList(exportFile, exportHeader)
 		if err != nil {
 			log.Fatalf("Error exporting roms list: %s\n", err)
 		}
 		return
 	}
 
-	device := new(GRSDevice)
-	device.Init()
+	if listRestrictors {
+		for _, r := range restrictors {
+			fmt.Printf("%s\t%s\n", r, restrictorLabels[r])
+		}
+		return
+	}
+
+	if audit {
+		if mameXMLPath == "" {
+			log.Fatalln("-audit requires -mamexml")
+		}
+		printAudit()
+		return
+	}
+
+	if coverageDir != "" {
+		if err := printCoverage(coverageDir); err != nil {
+			log.Fatalf("Error reading rom directory: %s\n", err)
+		}
+		return
+	}
+
+	device, err := Open(Options{
+		Path:          devicePath,
+		Baud:          baudRate,
+		Timeout:       readTimeout,
+		Retries:       retries,
+		WaitForDevice: waitForDevice,
+		SkipHandshake: !handshake && !ping && !silentOutput,
+		Simulate:      simulate,
+	})
+	if err != nil {
+		log.Printf("ERROR: %s\n", err)
+		os.Exit(exitDeviceError)
+	}
+	defer device.Close()
+
+	if ping {
+		return
+	}
+
+	if tcpAddr != "" {
+		log.Fatal(serveTCP(tcpAddr, device))
+	}
 
 	if rawComand != "" {
 		device.RawCommand(rawComand)
 		return
 	}
 
+	if verifyServos {
+		passed, err := device.VerifyServos()
+		if err != nil {
+			fatal(err)
+		}
+		if !passed {
+			log.Println("ERROR: servo verification failed")
+			os.Exit(exitVerifyFailed)
+		}
+		return
+	}
+
 	if getInfo {
 		device.GetInfo()
 		return
 	}
 
+	if unsaved {
+		device.PrintUnsaved()
+		return
+	}
+
 	if setWay != 0 {
 		if !isValidWay(setWay) {
 			log.Fatalf("invalid value for -way: %d\n", setWay)
@@ -416,6 +978,11 @@ func main() {
 		return
 	}
 
+	if setStartupWay != 0 {
+		device.SetStartupWay(deviceRestrictor, setStartupWay)
+		return
+	}
+
 	if autoRom != "" {
 		device.SetWayForRom(autoRom)
 		return
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// recorder records the commands sent to a simulator.
+type recorder struct {
+	*simulator
+	sent []string
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.sent = append(r.sent, string(b))
+	return r.simulator.Write(b)
+}
+
+func newRecordingDevice() (*GRSDevice, *recorder) {
+	r := &recorder{simulator: newSimulator()}
+	return &GRSDevice{device: r}, r
+}
+
+func TestSetStartupWay(t *testing.T) {
+	for _, way := range []int{4, 8} {
+		g, r := newRecordingDevice()
+		g.SetStartupWay("all", way)
+
+		want := []string{fmt.Sprintf("setstartupway,%d", way), "makepermanent"}
+		if !reflect.DeepEqual(r.sent, want) {
+			t.Errorf("SetStartupWay(all, %d) sent %q, want %q", way, r.sent, want)
+		}
+		if got := g.GetStartupWay(); got != way {
+			t.Errorf("GetStartupWay() = %d, want %d", got, way)
+		}
+	}
+}
+
+func TestSetStartupWayInvalid(t *testing.T) {
+	tests := []struct {
+		restrictor string
+		way        int
+	}{
+		{"a", 4},
+		{"d", 8},
+		{"all", 2},
+	}
+	for _, tt := range tests {
+		g, r := newRecordingDevice()
+		if err := g.setStartupWay(tt.restrictor, tt.way); err == nil {
+			t.Errorf("setStartupWay(%s, %d) succeeded, want error", tt.restrictor, tt.way)
+		}
+		if len(r.sent) != 0 {
+			t.Errorf("setStartupWay(%s, %d) sent %q, want nothing", tt.restrictor, tt.way, r.sent)
+		}
+	}
+}
+
+func TestOpenSimulateHandshake(t *testing.T) {
+	g, err := Open(Options{Simulate: true})
+	if err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	defer g.Close()
+	if err := g.Handshake(); err != nil {
+		t.Errorf("Handshake() = %v", err)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errDevice, exitDeviceError},
+		{fmt.Errorf("getstartupway: %w", errDevice), exitDeviceError},
+		{errGarbled, exitDeviceError},
+		{fmt.Errorf("getwelcome: %w", errGarbled), exitDeviceError},
+		{errors.New("invalid way: 5"), exitError},
+	}
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestIsFlagSet(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"-silent-output"}, true},
+		{[]string{"--silent-output"}, true},
+		{[]string{"-silent-output=true"}, true},
+		{[]string{"-silent-output=false"}, false},
+		{[]string{"-port", "/dev/ttyACM0", "-silent-output", "-bogus"}, true},
+		{[]string{"-bogus"}, false},
+		{[]string{"--", "-silent-output"}, false},
+	}
+	for _, tt := range tests {
+		if got := isFlagSet(tt.args, "silent-output"); got != tt.want {
+			t.Errorf("isFlagSet(%q) = %t, want %t", tt.args, got, tt.want)
+		}
+	}
+}
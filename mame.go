@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// A machineInfo holds the MAME metadata used to derive the way of a rom.
+type machineInfo struct {
+	// Ways is the way of the joystick, or 0 if the machine has no joystick.
+	Ways     int
+	Vertical bool
+}
+
+type mameMachine struct {
+	Name     string `xml:"name,attr"`
+	Displays []struct {
+		Rotate int `xml:"rotate,attr"`
+	} `xml:"display"`
+	Controls []struct {
+		Type string `xml:"type,attr"`
+		Ways string `xml:"ways,attr"`
+	} `xml:"input>control"`
+}
+
+// readMameXML reads the output of mame -listxml and returns the metadata of
+// each machine by name.
+func readMameXML(r io.Reader) (map[string]machineInfo, error) {
+	machines := make(map[string]machineInfo)
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return machines, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		// Older versions of MAME use game instead of machine.
+		if !ok || (start.Name.Local != "machine" && start.Name.Local != "game") {
+			continue
+		}
+		var m mameMachine
+		if err := decoder.DecodeElement(&m, &start); err != nil {
+			return nil, err
+		}
+
+		var info machineInfo
+		if len(m.Displays) > 0 {
+			info.Vertical = m.Displays[0].Rotate == 90 || m.Displays[0].Rotate == 270
+		}
+		for _, c := range m.Controls {
+			if c.Type != "joy" && c.Type != "doublejoy" && c.Type != "triplejoy" {
+				continue
+			}
+			// ways can have a suffix, e.g. "3 (half4)".
+			ways, _ := strconv.Atoi(strings.Fields(c.Ways + " 0")[0])
+			switch {
+			case ways == 8:
+				info.Ways = 8
+			case ways > 0:
+				info.Ways = 4
+			}
+			break
+		}
+		machines[m.Name] = info
+	}
+}
+
+func loadMameXML(path string) (map[string]machineInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readMameXML(f)
+}
+
+// deriveWay returns the way for a machine based on its metadata, or 0 if it
+// can not be derived. Vertical machines with a joystick are set to verticalWay
+// unless it is 0.
+func deriveWay(info machineInfo) int {
+	if info.Ways == 0 {
+		return 0
+	}
+	if info.Vertical && verticalWay != 0 {
+		return verticalWay
+	}
+	return info.Ways
+}
+
+// printAudit prints the roms in the rom list whose joystick ways in the MAME
+// metadata disagree with listWay.
+func printAudit() {
+	var mismatches, unknown int
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ROM\tLIST\tMAME")
+	for _, rom := range roms {
+		info, ok := mameMachines[strings.TrimSuffix(rom, filepath.Ext(rom))]
+		if !ok || info.Ways == 0 {
+			unknown++
+			continue
+		}
+		if info.Ways != listWay {
+			mismatches++
+			fmt.Fprintf(w, "%s\t%d-way\t%d-way\n", rom, listWay, info.Ways)
+		}
+	}
+	w.Flush()
+	fmt.Printf("%d of %d roms disagree with MAME, %d not found in MAME\n", mismatches, len(roms), unknown)
+}
+
+// mameWayForRom returns the way derived from the MAME metadata for rom, or 0
+// if it is unknown.
+func mameWayForRom(rom string) int {
+	base := filepath.Base(rom)
+	info, ok := mameMachines[strings.TrimSuffix(base, filepath.Ext(base))]
+	if !ok {
+		return 0
+	}
+	return deriveWay(info)
+}
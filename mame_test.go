@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const testMameXML = `<?xml version="1.0"?>
+<mame>
+	<machine name="galaga">
+		<display rotate="90"/>
+		<input players="2"><control type="joy" ways="2"/></input>
+	</machine>
+	<machine name="sf2">
+		<display rotate="0"/>
+		<input players="2"><control type="joy" ways="8"/></input>
+	</machine>
+	<machine name="robotron">
+		<display rotate="0"/>
+		<input players="2"><control type="doublejoy" ways="8" ways2="8"/></input>
+	</machine>
+	<machine name="centiped">
+		<display rotate="270"/>
+		<input players="2"><control type="trackball"/></input>
+	</machine>
+	<machine name="qbert">
+		<display rotate="270"/>
+		<input players="2"><control type="joy" ways="4"/></input>
+	</machine>
+	<machine name="1942">
+		<display rotate="270"/>
+		<input players="2"><control type="joy" ways="8"/></input>
+	</machine>
+</mame>`
+
+func TestMameWayForRom(t *testing.T) {
+	machines, err := readMameXML(strings.NewReader(testMameXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldVerticalWay := verticalWay
+	mameMachines = machines
+	defer func() { mameMachines, verticalWay = nil, oldVerticalWay }()
+
+	tests := []struct {
+		rom      string
+		vertical int
+		want     int
+	}{
+		{"galaga.zip", 4, 4},
+		{"sf2.zip", 4, 8},
+		{"/roms/robotron.zip", 4, 8},
+		{"centiped.zip", 4, 0},
+		{"qbert.zip", 8, 8},
+		{"1942.zip", 4, 4},
+		{"1942.zip", 0, 8},
+		{"unknown.zip", 4, 0},
+	}
+	for _, tt := range tests {
+		verticalWay = tt.vertical
+		if got := mameWayForRom(tt.rom); got != tt.want {
+			t.Errorf("mameWayForRom(%q) with verticalWay %d = %d, want %d", tt.rom, tt.vertical, got, tt.want)
+		}
+	}
+}
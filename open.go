@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// Options configures Open.
+type Options struct {
+	// Path is the path of the device. Empty or auto scans for a device.
+	Path string
+	// Baud is the baud rate. Defaults to 115200.
+	Baud int
+	// Timeout is the read timeout. 0 waits forever.
+	Timeout time.Duration
+	// Retries is the number of times to retry after a failed attempt.
+	Retries int
+	// RetryDelay is the delay before the first retry. It doubles after each
+	// retry. Defaults to 1 second.
+	RetryDelay time.Duration
+	// WaitForDevice is how long to wait for a device to show up when
+	// scanning. Retries are only counted once a device is found.
+	WaitForDevice time.Duration
+	// SkipHandshake skips verifying communication after opening the device.
+	SkipHandshake bool
+	// Simulate opens an in-memory simulated device instead of a tos428.
+	Simulate bool
+}
+
+// Open finds, opens and verifies a tos428, retrying with backoff on failure.
+// It is how the command opens the device; tos428 is a single main package, so
+// it can not be imported by other programs.
+func Open(opts Options) (*GRSDevice, error) {
+	if opts.Baud == 0 {
+		opts.Baud = 115200
+	}
+	if opts.RetryDelay == 0 {
+		opts.RetryDelay = time.Second
+	}
+	path := opts.Path
+	if opts.Simulate {
+		path = "simulator"
+	} else if path == "" || path == "auto" {
+		deadline := time.Now().Add(opts.WaitForDevice)
+		for {
+			p, err := findDevice()
+			if err == nil {
+				path = p
+				break
+			}
+			if time.Now().After(deadline) {
+				return nil, err
+			}
+			wait := time.Until(deadline)
+			if wait > time.Second {
+				wait = time.Second
+			}
+			time.Sleep(wait)
+		}
+	}
+
+	delay := opts.RetryDelay
+	var err error
+	for attempt := 0; ; attempt++ {
+		var g *GRSDevice
+		g, err = open(path, opts)
+		if err == nil {
+			return g, nil
+		}
+		if attempt >= opts.Retries {
+			break
+		}
+		log.Printf("Unable to open %s: %s. Retrying in %s", path, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+	return nil, fmt.Errorf("unable to open %s: %w", path, err)
+}
+
+func open(path string, opts Options) (*GRSDevice, error) {
+	var d io.ReadWriteCloser
+	if opts.Simulate {
+		d = newSimulator()
+	} else {
+		c := &serial.Config{Name: path, Baud: opts.Baud, ReadTimeout: opts.Timeout}
+		p, err := serial.OpenPort(c)
+		if err != nil {
+			return nil, err
+		}
+		d = p
+	}
+	g := &GRSDevice{device: d, timeout: opts.Timeout}
+	if !opts.SkipHandshake {
+		if err := g.Handshake(); err != nil {
+			g.Close()
+			return nil, fmt.Errorf("handshake failed: %w", err)
+		}
+	}
+	return g, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// A Server gives network clients access to a GRSDevice. Requests are
+// serialized so only one command is sent to the device at a time.
+type Server struct {
+	device *GRSDevice
+	mu     sync.Mutex
+
+	// statePath is the file the state is persisted to. Empty disables
+	// persistence.
+	statePath string
+	state     serverState
+}
+
+// serverState is the state of a Server that is persisted across restarts.
+type serverState struct {
+	// Locked rejects manual way changes, so a tuned cabinet can only be
+	// switched by rom.
+	Locked bool `json:"locked"`
+}
+
+// serveTCP serves the line protocol on addr until the listener fails.
+func serveTCP(addr string, g *GRSDevice) error {
+	s := &Server{device: g, statePath: statePath}
+	if err := s.loadState(); err != nil {
+		return err
+	}
+	if lockConfig && !s.state.Locked {
+		if err := s.setLocked(true); err != nil {
+			return err
+		}
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Listening on %s", l.Addr())
+
+	go s.reloadOnHangup()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	admin := ok && addr.IP.IsLoopback()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if _, err := fmt.Fprintln(conn, s.handleLine(line, admin)); err != nil {
+			return
+		}
+	}
+}
+
+// handleLine runs a single line protocol command and returns the one-line
+// response. lock and unlock are only accepted from admin (loopback) clients.
+func (s *Server) handleLine(line string, admin bool) string {
+	args := strings.Fields(line)
+	switch args[0] {
+	case "rom":
+		if len(args) != 2 {
+			return "error: usage: rom <name>"
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err := s.device.setWayForRom(args[1]); err != nil {
+			return fmt.Sprintf("error: %s", err)
+		}
+		return "ok"
+	case "way":
+		if len(args) != 3 {
+			return "error: usage: way <restrictor> <4|8>"
+		}
+		way, err := strconv.Atoi(args[2])
+		if err != nil || !isValidWay(way) {
+			return fmt.Sprintf("error: invalid way: %s", args[2])
+		}
+		restrictor := normalizeRestrictor(args[1])
+		if !isValidRestrictor(restrictor) {
+			return fmt.Sprintf("error: invalid restrictor: %s", args[1])
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.state.Locked {
+			return "error: locked"
+		}
+		if err := s.device.setPosition(restrictor, way); err != nil {
+			return fmt.Sprintf("error: %s", err)
+		}
+		return "ok"
+	case "lock", "unlock":
+		if !admin {
+			return fmt.Sprintf("error: %s is only accepted from localhost", args[0])
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err := s.setLocked(args[0] == "lock"); err != nil {
+			return fmt.Sprintf("error: %s", err)
+		}
+		return "ok"
+	case "info":
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		welcome, err := s.device.exec("getwelcome")
+		if err != nil {
+			return fmt.Sprintf("error: %s", err)
+		}
+		startupWay, err := s.device.getStartupWay()
+		if err != nil {
+			return fmt.Sprintf("error: %s", err)
+		}
+		return fmt.Sprintf("%s; startup way %d", welcome, startupWay)
+	default:
+		return fmt.Sprintf("error: unknown command: %s", args[0])
+	}
+}
+
+// loadState reads the persisted state, if any.
+func (s *Server) loadState() error {
+	if s.statePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &s.state)
+}
+
+// setLocked locks or unlocks the configuration and persists the state.
+func (s *Server) setLocked(locked bool) error {
+	s.state.Locked = locked
+	if locked {
+		log.Printf("Configuration locked")
+	} else {
+		log.Printf("Configuration unlocked")
+	}
+	if s.statePath == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.statePath, data, 0644)
+}
+
+// reloadOnHangup reloads the rom lists whenever SIGHUP is received, keeping
+// the connection to the device open. Device settings are only given by flags,
+// so there is nothing to reapply to the device.
+func (s *Server) reloadOnHangup() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		s.mu.Lock()
+		err := loadRomLists()
+		if err == nil {
+			log.Printf("Reloaded %d roms, %d layouts and %d MAME machines", len(roms), len(layouts), len(mameMachines))
+		}
+		s.mu.Unlock()
+		if err != nil {
+			log.Printf("ERROR: reload failed, keeping previous configuration: %s\n", err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// failingDevice answers every command with an error.
+type failingDevice struct {
+	output []byte
+}
+
+func (f *failingDevice) Write(b []byte) (int, error) {
+	f.output = []byte("error\r\n")
+	return len(b), nil
+}
+
+func (f *failingDevice) Read(b []byte) (int, error) {
+	n := copy(b, f.output)
+	f.output = f.output[n:]
+	return n, nil
+}
+
+func (f *failingDevice) Close() error {
+	return nil
+}
+
+func TestHandleLineDeviceError(t *testing.T) {
+	s := &Server{device: &GRSDevice{device: &failingDevice{}}}
+	for _, line := range []string{"rom pacman.zip", "way a 4", "info"} {
+		if got := s.handleLine(line, false); !strings.HasPrefix(got, "error: ") {
+			t.Errorf("handleLine(%q) = %q, want an error", line, got)
+		}
+	}
+}
+
+func TestHandleLine(t *testing.T) {
+	s := &Server{device: &GRSDevice{device: newSimulator()}}
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"way a 4", "ok"},
+		{"way e 4", "error: invalid restrictor: e"},
+		{"way a 5", "error: invalid way: 5"},
+		{"info", "tos428 simulator; startup way 8"},
+		{"bogus", "error: unknown command: bogus"},
+	}
+	for _, tt := range tests {
+		if got := s.handleLine(tt.line, false); got != tt.want {
+			t.Errorf("handleLine(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestHandleLineLock(t *testing.T) {
+	s := &Server{device: &GRSDevice{device: newSimulator()}}
+	tests := []struct {
+		line  string
+		admin bool
+		want  string
+	}{
+		{"lock", false, "error: lock is only accepted from localhost"},
+		{"lock", true, "ok"},
+		{"way a 4", true, "error: locked"},
+		{"rom pacman.zip", false, "ok"},
+		{"unlock", false, "error: unlock is only accepted from localhost"},
+		{"way a 4", false, "error: locked"},
+		{"unlock", true, "ok"},
+		{"way a 4", false, "ok"},
+	}
+	for _, tt := range tests {
+		if got := s.handleLine(tt.line, tt.admin); got != tt.want {
+			t.Errorf("handleLine(%q, %t) = %q, want %q", tt.line, tt.admin, got, tt.want)
+		}
+	}
+}
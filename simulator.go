@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// simulatorState is the configuration held by a simulator.
+type simulatorState struct {
+	startupWay int
+	silent     bool
+	colors     map[string]Color
+}
+
+func factoryState() simulatorState {
+	return simulatorState{
+		startupWay: 8,
+		colors: map[string]Color{
+			"4":        {Red: 255, Green: 0, Blue: 0},
+			"8":        {Red: 0, Green: 255, Blue: 0},
+			"keyboard": {Red: 0, Green: 0, Blue: 255},
+		},
+	}
+}
+
+func (s simulatorState) clone() simulatorState {
+	c := s
+	c.colors = make(map[string]Color)
+	for mode, color := range s.colors {
+		c.colors[mode] = color
+	}
+	return c
+}
+
+// A simulator is an in-memory tos428 that answers commands like the firmware,
+// so the tool can be used without hardware.
+type simulator struct {
+	live      simulatorState
+	permanent simulatorState
+	ways      map[string]int
+	output    []byte
+}
+
+func newSimulator() *simulator {
+	s := &simulator{
+		permanent: factoryState(),
+		ways:      make(map[string]int),
+	}
+	s.live = s.permanent.clone()
+	for _, r := range []string{"a", "b", "c", "d"} {
+		s.ways[r] = s.live.startupWay
+	}
+	return s
+}
+
+func (s *simulator) Write(b []byte) (int, error) {
+	s.output = []byte(s.handle(string(b)) + "\r\n")
+	return len(b), nil
+}
+
+func (s *simulator) Read(b []byte) (int, error) {
+	n := copy(b, s.output)
+	s.output = s.output[n:]
+	return n, nil
+}
+
+func (s *simulator) Close() error {
+	return nil
+}
+
+func (s *simulator) handle(cmd string) string {
+	args := strings.Split(cmd, ",")
+	switch args[0] {
+	case "getwelcome":
+		return "tos428 simulator"
+	case "getkeylist":
+		return "KEY_LEFT_CTRL\r\nKEY_LEFT_ALT\r\nKEY_DELETE"
+	case "dumpeeprom":
+		return fmt.Sprintf("startupway=%d silent=%t colors=%v", s.permanent.startupWay, s.permanent.silent, s.permanent.colors)
+	case "getcolor":
+		c, ok := s.live.colors[arg(args, 1)]
+		if !ok {
+			return "error"
+		}
+		return fmt.Sprintf("%d,%d,%d", c.Red, c.Green, c.Blue)
+	case "setcolor":
+		if len(args) != 5 || !isValidMode(args[1]) {
+			return "error"
+		}
+		var rgb [3]int
+		for i, v := range args[2:] {
+			c, err := strconv.Atoi(v)
+			if err != nil || !isValidColor(c) {
+				return "error"
+			}
+			rgb[i] = c
+		}
+		s.live.colors[args[1]] = Color{Red: rgb[0], Green: rgb[1], Blue: rgb[2]}
+		return "ok"
+	case "setway":
+		way, err := strconv.Atoi(arg(args, 2))
+		if err != nil || !isValidWay(way) || !isValidRestrictor(arg(args, 1)) {
+			return "error"
+		}
+		for r := range s.ways {
+			if args[1] == "all" || args[1] == r {
+				s.ways[r] = way
+			}
+		}
+		return "ok"
+	case "getstartupway":
+		return strconv.Itoa(s.live.startupWay)
+	case "setstartupway":
+		way, err := strconv.Atoi(arg(args, 1))
+		if err != nil || !isValidWay(way) {
+			return "error"
+		}
+		s.live.startupWay = way
+		return "ok"
+	case "getsilent":
+		return strconv.FormatBool(s.live.silent)
+	case "setsilent":
+		switch arg(args, 1) {
+		case "on":
+			s.live.silent = true
+		case "off":
+			s.live.silent = false
+		default:
+			return "error"
+		}
+		return "ok"
+	case "makepermanent":
+		s.permanent = s.live.clone()
+		return "ok"
+	case "restorefactory":
+		s.live = factoryState()
+		return "ok"
+	default:
+		return "unknown command"
+	}
+}
+
+// arg returns args[i], or an empty string if there are not enough args.
+func arg(args []string, i int) string {
+	if i >= len(args) {
+		return ""
+	}
+	return args[i]
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+)
+
+// VerifyServos moves each restrictor to 4-way and then 8-way, prints a table
+// of which moves the device acknowledged and returns true if all were
+// acknowledged. If the device stops responding the sweep is aborted and the
+// error returned.
+//
+// The firmware can not report the position of a restrictor, so a stuck servo
+// is not detected and the positions before the sweep are unknown. Afterwards
+// all restrictors are reset to the startup way.
+func (g *GRSDevice) VerifyServos() (bool, error) {
+	startupWay, err := g.getStartupWay()
+	if err != nil {
+		return false, fmt.Errorf("unable to get startup way: %w", err)
+	}
+
+	passed := true
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RESTRICTOR\t4-WAY ACK\t8-WAY ACK")
+	for _, r := range restrictors {
+		fmt.Fprint(w, restrictorName(r))
+		for _, way := range []int{4, 8} {
+			result := "ok"
+			if err := g.applySetting(fmt.Sprintf("setway,%s,%d", r, way)); errors.Is(err, errDevice) {
+				w.Flush()
+				return false, fmt.Errorf("restrictor %s: %w", r, err)
+			} else if err != nil {
+				log.Printf("ERROR: restrictor %s: %s", r, err)
+				result = "FAIL"
+				passed = false
+			}
+			fmt.Fprintf(w, "\t%s", result)
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+
+	fmt.Println("Only the acknowledgement of each move is checked; positions can not be read back.")
+
+	if err := g.applySetting(fmt.Sprintf("setway,all,%d", startupWay)); errors.Is(err, errDevice) {
+		return false, fmt.Errorf("unable to reset restrictors to the startup way (%d-way): %w", startupWay, err)
+	} else if err != nil {
+		log.Printf("ERROR: unable to reset restrictors to the startup way (%d-way): %s", startupWay, err)
+		return false, nil
+	}
+	fmt.Printf("Reset all restrictors to the startup way (%d-way).\n", startupWay)
+	return passed, nil
+}
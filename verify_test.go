@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// dyingDevice is a simulator that stops responding after a number of
+// commands.
+type dyingDevice struct {
+	*simulator
+	commands int
+}
+
+func (d *dyingDevice) Write(b []byte) (int, error) {
+	d.commands--
+	return d.simulator.Write(b)
+}
+
+func (d *dyingDevice) Read(b []byte) (int, error) {
+	if d.commands < 0 {
+		return 0, nil
+	}
+	return d.simulator.Read(b)
+}
+
+func TestVerifyServos(t *testing.T) {
+	g, r := newRecordingDevice()
+	passed, err := g.VerifyServos()
+	if err != nil || !passed {
+		t.Fatalf("VerifyServos() = %t, %v, want true, nil", passed, err)
+	}
+	if got, want := r.sent[len(r.sent)-1], "setway,all,8"; got != want {
+		t.Errorf("VerifyServos() sent %q last, want %q", got, want)
+	}
+}
+
+func TestVerifyServosDeviceError(t *testing.T) {
+	// getstartupway and the 4-way move of restrictor a are answered.
+	g := &GRSDevice{device: &dyingDevice{simulator: newSimulator(), commands: 2}}
+	passed, err := g.VerifyServos()
+	if passed || !errors.Is(err, errDevice) {
+		t.Errorf("VerifyServos() = %t, %v, want false, %v", passed, err, errDevice)
+	}
+}